@@ -2,60 +2,176 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/redis/go-redis/v9"
 	"golang.org/x/sync/singleflight"
 )
 
-type RedisClient struct {
-	client *redis.Client
-}
+// ErrNotFound はネガティブキャッシュ有効時に、callbackがnot foundエラーを返した場合と
+// ネガティブキャッシュがヒットした場合に返される。callbackが返した元のエラーは
+// 保持されないため、呼び出し側はこのエラーをチェックする
+var ErrNotFound = errors.New("cache: not found")
+
+// negativePlaceholder はネガティブキャッシュであることを示すマーカー。codecでエンコードされた
+// 値と衝突しないよう専用のバイト列にしている
+var negativePlaceholder = []byte("\x00cache:not-found\x00")
+
+// negativeCacheHit はsingleflight.Doの戻り値として、ネガティブキャッシュがヒットしたことを伝える
+type negativeCacheHit struct{}
+
+// releaseLockScript はtokenが一致する場合のみロックキーをDELする（所有者以外がロックを奪わないように）
+const releaseLockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// invalidateTagScript はタグSetのメンバーを全てDELしてから、タグSet自体もDELする
+const invalidateTagScript = `
+local members = redis.call("SMEMBERS", KEYS[1])
+for _, member in ipairs(members) do
+	redis.call("DEL", member)
+end
+redis.call("DEL", KEYS[1])
+return #members
+`
 
 type Cache[T any] struct {
 	client     RedisClient
 	expiration time.Duration
 	sfg        *singleflight.Group
+
+	// 分散singleflight用。lockTTLが0の場合は無効
+	lockTTL          time.Duration
+	lockPollInterval time.Duration
+	lockMaxWait      time.Duration
+
+	// RESP3のクライアントサイドキャッシュ用TTL。0の場合はクライアントサイドキャッシュを使わない
+	clientSideTTL time.Duration
+
+	codec Codec
+
+	// ネガティブキャッシュ用。negativeExpirationが0の場合は無効
+	negativeExpiration time.Duration
+	isNotFound         func(error) bool
+
+	// statがnilの場合は統計を収集しない
+	stat *Stat
+	// statsDoneはlogStatsPeriodicallyを止めるためのチャネル。ログ出力を有効にしていない場合はnil
+	statsDone chan struct{}
+}
+
+type CacheOption[T any] func(*Cache[T])
+
+// WithDistributedLock はRedisのロックを使ってプロセスをまたいだキャッシュフィルの重複排除を行う
+// 「分散singleflight」を有効にする。複数のアプリレプリカが同時にキャッシュミスした場合、
+// ロックを取れたプロセスだけがcallbackを実行し、他はpollIntervalごとに本物のキャッシュを
+// 最大maxWaitだけポーリングする。maxWaitを過ぎても埋まらなければ自分でcallbackを実行する。
+func WithDistributedLock[T any](lockTTL, pollInterval, maxWait time.Duration) CacheOption[T] {
+	return func(c *Cache[T]) {
+		if pollInterval <= 0 {
+			log.Printf("WithDistributedLock: pollInterval must be > 0 (got %s); distributed lock disabled", pollInterval)
+			return
+		}
+		c.lockTTL = lockTTL
+		c.lockPollInterval = pollInterval
+		c.lockMaxWait = maxWait
+	}
+}
+
+// WithClientSideTTL はrueidisバックエンド使用時にRESP3のクライアントサイドキャッシュ(DoCache)を
+// 有効にする。goredisバックエンドではttlは無視され、通常のGETにフォールバックする。
+func WithClientSideTTL[T any](ttl time.Duration) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.clientSideTTL = ttl
+	}
+}
+
+// WithCodec はRedisに保存する値のシリアライズ方式を切り替える。未指定の場合はJSONCodecを使う
+func WithCodec[T any](codec Codec) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.codec = codec
+	}
+}
+
+// WithNegativeCache は callback が "not found" エラー（デフォルトではsql.ErrNoRows）を返した場合に、
+// 見つからなかったこと自体を negativeExpiration だけ短くキャッシュし、以降の呼び出しを
+// DBまで到達させないようにする。isNotFoundを指定すると判定方法をカスタマイズできる
+func WithNegativeCache[T any](negativeExpiration time.Duration, isNotFound ...func(error) bool) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.negativeExpiration = negativeExpiration
+		if len(isNotFound) > 0 {
+			c.isNotFound = isNotFound[0]
+		}
+	}
 }
 
-func NewCache[T any](client RedisClient, expiration time.Duration) *Cache[T] {
-	return &Cache[T]{
+func NewCache[T any](client RedisClient, expiration time.Duration, opts ...CacheOption[T]) *Cache[T] {
+	c := &Cache[T]{
 		client:     client,
 		expiration: expiration,
 		sfg:        &singleflight.Group{},
+		codec:      JSONCodec{},
+		isNotFound: func(err error) bool {
+			return errors.Is(err, sql.ErrNoRows)
+		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func NewRedisClient(ctx context.Context) *RedisClient {
-	client := redis.NewClient(&redis.Options{
-		Addr:         "127.0.0.1:6379",
-		DB:           0,
-		PoolSize:     20,
-		MinIdleConns: 10,
-	})
+func (c *Cache[T]) negativeCacheEnabled() bool {
+	return c.negativeExpiration > 0
+}
 
-	// 疎通確認
-	if err := client.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+// WithStats はcacheNameで名付けたStatをCache[T]に取り付け、GetOrSetのヒット・ミス・DBクエリ・
+// エラー・レイテンシを計測する。logIntervalが0より大きい場合、その間隔でサマリ行をログ出力する
+func WithStats[T any](cacheName string, logInterval time.Duration) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.stat = newStat(cacheName)
+		if logInterval > 0 {
+			c.statsDone = make(chan struct{})
+			go c.logStatsPeriodically(logInterval)
+		}
 	}
+}
+
+func (c *Cache[T]) logStatsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	return &RedisClient{
-		client: client,
+	for {
+		select {
+		case <-c.statsDone:
+			return
+		case <-ticker.C:
+			log.Println(c.stat.String())
+		}
 	}
 }
 
-// Redisクライアントの接続を閉じる
-func (c *RedisClient) Close() {
-	defer c.client.Close()
+// Stats はCache[T]に取り付けられた統計情報を返す。WithStatsを指定していない場合はnil
+func (c *Cache[T]) Stats() *Stat {
+	return c.stat
 }
 
-func (c *RedisClient) Clear() error {
-	return c.client.FlushAll(context.Background()).Err()
+// Close はWithStatsのlogIntervalで起動したログ出力ゴルーチンを止める。
+// ログ出力を有効にしていない場合は何もしない。Cache[T]を使い終わったら呼ぶこと
+func (c *Cache[T]) Close() {
+	if c.statsDone != nil {
+		close(c.statsDone)
+	}
 }
 
 type redisRepository[T any] struct {
@@ -66,12 +182,14 @@ type redisRepository[T any] struct {
 func NewRedisRepository[T any](
 	db *sqlx.DB,
 	cacheClient RedisClient,
+	opts ...CacheOption[T],
 ) *redisRepository[T] {
 	return &redisRepository[T]{
 		db: db,
 		cache: NewCache[T](
 			cacheClient,
 			time.Second*10,
+			opts...,
 		),
 	}
 }
@@ -83,24 +201,71 @@ func (c *Cache[T]) GetOrSet(
 	callback func(context.Context) (T, error), // キャッシュがなければDBにインサートする
 	extraSetFunc ...func(bytes []byte) error,
 ) (T, error) {
+	start := time.Now()
+	defer func() {
+		if c.stat != nil {
+			c.stat.observeLatency(time.Since(start))
+		}
+	}()
+
 	// singleflightでリクエストをまとめる
 	res, err, _ := c.sfg.Do(cacheKey, func() (any, error) {
-		// キャッシュから取得
-		bytes, exist, err := c.client.Get(ctx, cacheKey)
+		// キャッシュから取得（rueidisバックエンドではRESP3のクライアントサイドキャッシュを使う）
+		bytes, exist, err := c.client.GetCached(ctx, cacheKey, c.clientSideTTL)
 		if err != nil {
 			log.Println(err.Error())
 		}
 		if exist {
+			if c.negativeCacheEnabled() && string(bytes) == string(negativePlaceholder) {
+				if c.stat != nil {
+					c.stat.Hits.Add(1)
+				}
+				return negativeCacheHit{}, nil
+			}
+			if c.stat != nil {
+				c.stat.Hits.Add(1)
+			}
 			return bytes, nil
 		}
 
+		if c.stat != nil {
+			c.stat.Misses.Add(1)
+		}
+
 		// キャッシュがなければcallbackを実行
+		if c.distributedLockEnabled() {
+			return c.getOrSetWithLock(ctx, cacheKey, callback, extraSetFunc...)
+		}
+
 		t, err := callback(ctx)
 		if err != nil {
+			if c.negativeCacheEnabled() && c.isNotFound(err) {
+				if c.stat != nil {
+					c.stat.DBQueries.Add(1)
+				}
+				if setErr := c.client.Set(ctx, cacheKey, negativePlaceholder, c.negativeExpiration); setErr != nil {
+					log.Println(setErr.Error())
+				}
+				if len(extraSetFunc) > 0 {
+					if err := extraSetFunc[0](negativePlaceholder); err != nil {
+						log.Println(err.Error())
+					}
+				}
+				return negativeCacheHit{}, nil
+			}
+			if c.stat != nil {
+				c.stat.DBErrors.Add(1)
+			}
 			return nil, err
 		}
-		bytes, err = json.Marshal(t)
+		if c.stat != nil {
+			c.stat.DBQueries.Add(1)
+		}
+		bytes, err = c.codec.Marshal(t)
 		if err != nil {
+			if c.stat != nil {
+				c.stat.MarshalErrors.Add(1)
+			}
 			return nil, err
 		}
 
@@ -125,95 +290,172 @@ func (c *Cache[T]) GetOrSet(
 		return value, err
 	}
 
+	if _, ok := res.(negativeCacheHit); ok {
+		return value, ErrNotFound
+	}
+
 	bytes, ok := res.([]byte)
 	if !ok {
 		// 実装上、起きることはないはず
 		return value, fmt.Errorf("failed to get from cache: invalid type %T", res)
 	}
 
-	err = json.Unmarshal(bytes, &value)
+	err = c.codec.Unmarshal(bytes, &value)
 	if err != nil {
+		if c.stat != nil {
+			c.stat.UnmarshalErrors.Add(1)
+		}
 		return value, err
 	}
 
 	return value, nil
 }
 
-// キャッシュを取得する
-func (c *RedisClient) Get(
+func (c *Cache[T]) distributedLockEnabled() bool {
+	return c.lockTTL > 0
+}
+
+// getOrSetWithLock はRedisのロックを取得できたプロセスだけにcallbackを実行させる。
+// ロックを取れなかったプロセスは本物のキャッシュが埋まるのをポーリングで待ち、
+// lockMaxWaitを過ぎても埋まらなければ自分でcallbackを実行してフォールバックする。
+func (c *Cache[T]) getOrSetWithLock(
 	ctx context.Context,
-	key string,
-) ([]byte, bool, error) {
-	bytes, err := c.client.Get(ctx, key).Bytes()
-	// キャッシュが存在しない場合
-	if err == redis.Nil {
-		return nil, false, nil
-	}
+	cacheKey string,
+	callback func(context.Context) (T, error),
+	extraSetFunc ...func(bytes []byte) error,
+) (any, error) {
+	lockKey := cacheKey + ":lock"
+	token := newLockToken()
 
+	acquired, err := c.client.SetNX(ctx, lockKey, token, c.lockTTL)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+		log.Println(err.Error())
 	}
 
-	// キャッシュが存在する場合
-	return bytes, true, nil
-}
+	if !acquired {
+		if bytes, ok := c.waitForCache(ctx, cacheKey); ok {
+			if c.negativeCacheEnabled() && string(bytes) == string(negativePlaceholder) {
+				return negativeCacheHit{}, nil
+			}
+			return bytes, nil
+		}
+	} else {
+		defer c.releaseLock(ctx, lockKey, token)
+	}
 
-// redisにvalueをsetする
-func (c *RedisClient) Set(
-	ctx context.Context,
-	key string,
-	bytes []byte,
-	expiration time.Duration,
-) error {
-	err := c.client.Set(ctx, key, bytes, expiration).Err()
+	t, err := callback(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to set to redis: %w", err)
+		if c.negativeCacheEnabled() && c.isNotFound(err) {
+			if c.stat != nil {
+				c.stat.DBQueries.Add(1)
+			}
+			if setErr := c.client.Set(ctx, cacheKey, negativePlaceholder, c.negativeExpiration); setErr != nil {
+				log.Println(setErr.Error())
+			}
+			if len(extraSetFunc) > 0 {
+				if err := extraSetFunc[0](negativePlaceholder); err != nil {
+					log.Println(err.Error())
+				}
+			}
+			return negativeCacheHit{}, nil
+		}
+		if c.stat != nil {
+			c.stat.DBErrors.Add(1)
+		}
+		return nil, err
 	}
-	return nil
-}
-
-// redisからvalueを削除する
-func (c *RedisClient) Del(
-	ctx context.Context,
-	key string,
-) error {
-	err := c.client.Del(ctx, key).Err()
+	if c.stat != nil {
+		c.stat.DBQueries.Add(1)
+	}
+	bytes, err := c.codec.Marshal(t)
 	if err != nil {
-		return fmt.Errorf("failed to delete from redis: %w", err)
+		if c.stat != nil {
+			c.stat.MarshalErrors.Add(1)
+		}
+		return nil, err
 	}
-	return nil
+
+	if err := c.client.Set(ctx, cacheKey, bytes, c.expiration); err != nil {
+		log.Println(err.Error())
+	}
+
+	if len(extraSetFunc) > 0 {
+		if err := extraSetFunc[0](bytes); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return bytes, nil
 }
 
-// キャッシュを取得する
-func (c *RedisClient) MGet(
-	ctx context.Context,
-	keys []string,
-) ([]interface{}, bool, error) {
-	result, err := c.client.MGet(ctx, keys...).Result()
+// waitForCache はロックを取れなかった場合に、ロック保持者が埋めた本物のキャッシュを
+// pollIntervalごとにmaxWaitまでポーリングする
+func (c *Cache[T]) waitForCache(ctx context.Context, cacheKey string) ([]byte, bool) {
+	deadline := time.Now().Add(c.lockMaxWait)
+	ticker := time.NewTicker(c.lockPollInterval)
+	defer ticker.Stop()
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+			if bytes, exist, err := c.client.Get(ctx, cacheKey); err == nil && exist {
+				return bytes, true
+			}
+		}
+	}
 
-	if err == redis.Nil {
-		return nil, false, nil
+	return nil, false
+}
+
+// releaseLock はLuaスクリプトでtokenを比較してからDELし、所有者以外がロックを奪うのを防ぐ
+func (c *Cache[T]) releaseLock(ctx context.Context, lockKey, token string) {
+	if _, err := c.client.Eval(ctx, releaseLockScript, []string{lockKey}, token); err != nil {
+		log.Println(err.Error())
 	}
+}
 
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+func newLockToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
+	return hex.EncodeToString(buf)
+}
 
-	return result, true, nil
+// Del はキーのキャッシュを削除する
+func (c *Cache[T]) Del(ctx context.Context, cacheKey string) error {
+	return c.client.Del(ctx, cacheKey)
 }
 
-// redisに複数のvalueをsetする
-func (c *RedisClient) MSet(
-	ctx context.Context,
-	values map[string]interface{},
-) error {
-	err := c.client.MSet(ctx, values).Err()
+// InvalidatePrefix はmatchPatternに一致する全キーをSCAN+パイプラインDELで削除する
+func (c *Cache[T]) InvalidatePrefix(ctx context.Context, matchPattern string) error {
+	_, err := c.client.ScanDel(ctx, matchPattern)
+	return err
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to set to redis: %w", err)
+// TagSetFunc はcacheKeyを指定したタグのRedis Setに登録するextraSetFuncを返す。
+// GetOrSetのextraSetFuncとして渡すことで、キャッシュを書き込むたびにタグ付けされる
+func (c *Cache[T]) TagSetFunc(ctx context.Context, cacheKey string, tags ...string) func([]byte) error {
+	return func([]byte) error {
+		for _, tag := range tags {
+			if err := c.client.SAdd(ctx, tagSetKey(tag), cacheKey); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+}
 
-	return nil
+// InvalidateTag はtagに紐づく全てのキャッシュキーをLuaスクリプトで一括削除する
+func (c *Cache[T]) InvalidateTag(ctx context.Context, tag string) error {
+	_, err := c.client.Eval(ctx, invalidateTagScript, []string{tagSetKey(tag)})
+	return err
+}
+
+func tagSetKey(tag string) string {
+	return "tag:" + tag
 }
 
 func (r *redisRepository[T]) GetByColumn(
@@ -246,6 +488,56 @@ func (r *redisRepository[T]) GetByColumn(
 	)
 }
 
+// GetByColumnWithTags はGetByColumnと同様だが、書き込まれたキャッシュキーを指定したタグに
+// 登録する。InvalidateTagで関連するキャッシュをまとめて無効化できるようになる
+func (r *redisRepository[T]) GetByColumnWithTags(
+	ctx context.Context,
+	columnName string,
+	columnValue string,
+	tableName string,
+	tags []string,
+	columns ...string,
+) (T, error) {
+	cacheKey := fmt.Sprintf("%s:%s:%s", tableName, columnName, columnValue)
+
+	return r.cache.GetOrSet(
+		ctx, cacheKey, func(ctx context.Context) (T, error) {
+			var result T
+			dest := any(&result)
+
+			selectColumns := "*"
+			if len(columns) > 0 {
+				selectColumns = strings.Join(columns, ", ")
+			}
+
+			query := fmt.Sprintf("SELECT %s FROM `%s` WHERE %s = ?", selectColumns, tableName, columnName)
+
+			if err := r.db.GetContext(ctx, dest, query, columnValue); err != nil {
+				return result, err
+			}
+
+			return result, nil
+		},
+		r.cache.TagSetFunc(ctx, cacheKey, tags...),
+	)
+}
+
+// Invalidate は指定したcolumnName/columnValueに対応するキャッシュを削除する
+func (r *redisRepository[T]) Invalidate(ctx context.Context, tableName, columnName, columnValue string) error {
+	cacheKey := fmt.Sprintf("%s:%s:%s", tableName, columnName, columnValue)
+	return r.cache.Del(ctx, cacheKey)
+}
+
+// InvalidateTable はtableNameに紐づく全てのキャッシュキーをSCAN+パイプラインDELで削除する
+func (r *redisRepository[T]) InvalidateTable(ctx context.Context, tableName string) error {
+	return r.cache.InvalidatePrefix(ctx, fmt.Sprintf("%s:*", tableName))
+}
+
+// InvalidateTag は指定したタグに紐づく全てのキャッシュキーを一括で削除する
+func (r *redisRepository[T]) InvalidateTag(ctx context.Context, tag string) error {
+	return r.cache.InvalidateTag(ctx, tag)
+}
+
 func (r *redisRepository[T]) GetById(
 	ctx context.Context,
 	id string,
@@ -0,0 +1,434 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound はネガティブキャッシュ有効時に、callbackがnot foundエラーを返した場合と
+// ネガティブキャッシュがヒットした場合に返される。callbackが返した元のエラーは
+// 保持されないため、呼び出し側はこのエラーをチェックする
+var ErrNotFound = errors.New("cache: not found")
+
+// negativePlaceholder はネガティブキャッシュであることを示すマーカー。codecでエンコードされた
+// 値と衝突しないよう専用のバイト列にしている
+var negativePlaceholder = []byte("\x00cache:not-found\x00")
+
+// negativeCacheHit はsingleflight.Doの戻り値として、ネガティブキャッシュがヒットしたことを伝える
+type negativeCacheHit struct{}
+
+// releaseLockScript はtokenが一致する場合のみロックキーをDELする（所有者以外がロックを奪わないように）
+const releaseLockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// invalidateTagScript はタグSetのメンバーを全てDELしてから、タグSet自体もDELする
+const invalidateTagScript = `
+local members = redis.call("SMEMBERS", KEYS[1])
+for _, member in ipairs(members) do
+	redis.call("DEL", member)
+end
+redis.call("DEL", KEYS[1])
+return #members
+`
+
+type Cache[T any] struct {
+	client     Client
+	expiration time.Duration
+	sfg        *singleflight.Group
+
+	// 分散singleflight用。lockTTLが0の場合は無効
+	lockTTL          time.Duration
+	lockPollInterval time.Duration
+	lockMaxWait      time.Duration
+
+	// RESP3のクライアントサイドキャッシュ用TTL。0の場合はクライアントサイドキャッシュを使わない
+	clientSideTTL time.Duration
+
+	codec Codec
+
+	// ネガティブキャッシュ用。negativeExpirationが0の場合は無効
+	negativeExpiration time.Duration
+	isNotFound         func(error) bool
+
+	// statがnilの場合は統計を収集しない
+	stat *Stat
+	// statsDoneはlogStatsPeriodicallyを止めるためのチャネル。ログ出力を有効にしていない場合はnil
+	statsDone chan struct{}
+}
+
+type CacheOption[T any] func(*Cache[T])
+
+// WithDistributedLock はRedisのロックを使ってプロセスをまたいだキャッシュフィルの重複排除を行う
+// 「分散singleflight」を有効にする。複数のアプリレプリカが同時にキャッシュミスした場合、
+// ロックを取れたプロセスだけがcallbackを実行し、他はpollIntervalごとに本物のキャッシュを
+// 最大maxWaitだけポーリングする。maxWaitを過ぎても埋まらなければ自分でcallbackを実行する。
+func WithDistributedLock[T any](lockTTL, pollInterval, maxWait time.Duration) CacheOption[T] {
+	return func(c *Cache[T]) {
+		if pollInterval <= 0 {
+			log.Printf("WithDistributedLock: pollInterval must be > 0 (got %s); distributed lock disabled", pollInterval)
+			return
+		}
+		c.lockTTL = lockTTL
+		c.lockPollInterval = pollInterval
+		c.lockMaxWait = maxWait
+	}
+}
+
+// WithClientSideTTL はRueidisClient使用時にRESP3のクライアントサイドキャッシュ(DoCache)を
+// 有効にする。GoRedisClientではttlは無視され、通常のGETにフォールバックする。
+func WithClientSideTTL[T any](ttl time.Duration) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.clientSideTTL = ttl
+	}
+}
+
+// WithCodec はRedisに保存する値のシリアライズ方式を切り替える。未指定の場合はJSONCodecを使う
+func WithCodec[T any](codec Codec) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.codec = codec
+	}
+}
+
+// WithNegativeCache は callback が "not found" エラー（デフォルトではsql.ErrNoRows）を返した場合に、
+// 見つからなかったこと自体を negativeExpiration だけ短くキャッシュし、以降の呼び出しを
+// DBまで到達させないようにする。isNotFoundを指定すると判定方法をカスタマイズできる
+func WithNegativeCache[T any](negativeExpiration time.Duration, isNotFound ...func(error) bool) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.negativeExpiration = negativeExpiration
+		if len(isNotFound) > 0 {
+			c.isNotFound = isNotFound[0]
+		}
+	}
+}
+
+func NewCache[T any](client Client, expiration time.Duration, opts ...CacheOption[T]) *Cache[T] {
+	c := &Cache[T]{
+		client:     client,
+		expiration: expiration,
+		sfg:        &singleflight.Group{},
+		codec:      JSONCodec{},
+		isNotFound: func(err error) bool {
+			return errors.Is(err, sql.ErrNoRows)
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Cache[T]) negativeCacheEnabled() bool {
+	return c.negativeExpiration > 0
+}
+
+// WithStats はcacheNameで名付けたStatをCache[T]に取り付け、GetOrSetのヒット・ミス・DBクエリ・
+// エラー・レイテンシを計測する。logIntervalが0より大きい場合、その間隔でサマリ行をログ出力する
+func WithStats[T any](cacheName string, logInterval time.Duration) CacheOption[T] {
+	return func(c *Cache[T]) {
+		c.stat = newStat(cacheName)
+		if logInterval > 0 {
+			c.statsDone = make(chan struct{})
+			go c.logStatsPeriodically(logInterval)
+		}
+	}
+}
+
+func (c *Cache[T]) logStatsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.statsDone:
+			return
+		case <-ticker.C:
+			log.Println(c.stat.String())
+		}
+	}
+}
+
+// Stats はCache[T]に取り付けられた統計情報を返す。WithStatsを指定していない場合はnil
+func (c *Cache[T]) Stats() *Stat {
+	return c.stat
+}
+
+// Close はWithStatsのlogIntervalで起動したログ出力ゴルーチンを止める。
+// ログ出力を有効にしていない場合は何もしない。Cache[T]を使い終わったら呼ぶこと
+func (c *Cache[T]) Close() {
+	if c.statsDone != nil {
+		close(c.statsDone)
+	}
+}
+
+// キャッシュがあれば取得する、なければセットする
+func (c *Cache[T]) GetOrSet(
+	ctx context.Context,
+	cacheKey string, // ユーザーのkey
+	callback func(context.Context) (T, error), // キャッシュがなければDBにインサートする
+	extraSetFunc ...func(bytes []byte) error,
+) (T, error) {
+	start := time.Now()
+	defer func() {
+		if c.stat != nil {
+			c.stat.observeLatency(time.Since(start))
+		}
+	}()
+
+	// singleflightでリクエストをまとめる
+	res, err, _ := c.sfg.Do(cacheKey, func() (any, error) {
+		// キャッシュから取得（RueidisClientの場合はRESP3のクライアントサイドキャッシュを使う）
+		bytes, exist, err := c.client.GetCached(ctx, cacheKey, c.clientSideTTL)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		if exist {
+			if c.negativeCacheEnabled() && string(bytes) == string(negativePlaceholder) {
+				if c.stat != nil {
+					c.stat.Hits.Add(1)
+				}
+				return negativeCacheHit{}, nil
+			}
+			if c.stat != nil {
+				c.stat.Hits.Add(1)
+			}
+			return bytes, nil
+		}
+
+		if c.stat != nil {
+			c.stat.Misses.Add(1)
+		}
+
+		// キャッシュがなければcallbackを実行
+		if c.distributedLockEnabled() {
+			return c.getOrSetWithLock(ctx, cacheKey, callback, extraSetFunc...)
+		}
+
+		t, err := callback(ctx)
+		if err != nil {
+			if c.negativeCacheEnabled() && c.isNotFound(err) {
+				if c.stat != nil {
+					c.stat.DBQueries.Add(1)
+				}
+				if setErr := c.client.Set(ctx, cacheKey, negativePlaceholder, c.negativeExpiration); setErr != nil {
+					log.Println(setErr.Error())
+				}
+				if len(extraSetFunc) > 0 {
+					if err := extraSetFunc[0](negativePlaceholder); err != nil {
+						log.Println(err.Error())
+					}
+				}
+				return negativeCacheHit{}, nil
+			}
+			if c.stat != nil {
+				c.stat.DBErrors.Add(1)
+			}
+			return nil, err
+		}
+		if c.stat != nil {
+			c.stat.DBQueries.Add(1)
+		}
+		bytes, err = c.codec.Marshal(t)
+		if err != nil {
+			if c.stat != nil {
+				c.stat.MarshalErrors.Add(1)
+			}
+			return nil, err
+		}
+
+		// キャッシュに保存
+		if err := c.client.Set(ctx, cacheKey, bytes, c.expiration); err != nil {
+			log.Println(err.Error())
+		}
+
+		if len(extraSetFunc) > 0 {
+			if err := extraSetFunc[0](bytes); err != nil {
+				log.Println(err.Error())
+			}
+		}
+
+		return bytes, nil
+	})
+
+	var value T
+	if err != nil {
+		return value, err
+	}
+
+	if _, ok := res.(negativeCacheHit); ok {
+		return value, ErrNotFound
+	}
+
+	bytes, ok := res.([]byte)
+	if !ok {
+		// 実装上、起きることはないはず
+		return value, fmt.Errorf("failed to get from cache: invalid type %T", res)
+	}
+
+	if err := c.codec.Unmarshal(bytes, &value); err != nil {
+		if c.stat != nil {
+			c.stat.UnmarshalErrors.Add(1)
+		}
+		return value, err
+	}
+
+	return value, nil
+}
+
+func (c *Cache[T]) distributedLockEnabled() bool {
+	return c.lockTTL > 0
+}
+
+// getOrSetWithLock はRedisのロックを取得できたプロセスだけにcallbackを実行させる。
+// ロックを取れなかったプロセスは本物のキャッシュが埋まるのをポーリングで待ち、
+// lockMaxWaitを過ぎても埋まらなければ自分でcallbackを実行してフォールバックする。
+func (c *Cache[T]) getOrSetWithLock(
+	ctx context.Context,
+	cacheKey string,
+	callback func(context.Context) (T, error),
+	extraSetFunc ...func(bytes []byte) error,
+) (any, error) {
+	lockKey := cacheKey + ":lock"
+	token := newLockToken()
+
+	acquired, err := c.client.SetNX(ctx, lockKey, token, c.lockTTL)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	if !acquired {
+		if bytes, ok := c.waitForCache(ctx, cacheKey); ok {
+			if c.negativeCacheEnabled() && string(bytes) == string(negativePlaceholder) {
+				return negativeCacheHit{}, nil
+			}
+			return bytes, nil
+		}
+	} else {
+		defer c.releaseLock(ctx, lockKey, token)
+	}
+
+	t, err := callback(ctx)
+	if err != nil {
+		if c.negativeCacheEnabled() && c.isNotFound(err) {
+			if c.stat != nil {
+				c.stat.DBQueries.Add(1)
+			}
+			if setErr := c.client.Set(ctx, cacheKey, negativePlaceholder, c.negativeExpiration); setErr != nil {
+				log.Println(setErr.Error())
+			}
+			if len(extraSetFunc) > 0 {
+				if err := extraSetFunc[0](negativePlaceholder); err != nil {
+					log.Println(err.Error())
+				}
+			}
+			return negativeCacheHit{}, nil
+		}
+		if c.stat != nil {
+			c.stat.DBErrors.Add(1)
+		}
+		return nil, err
+	}
+	if c.stat != nil {
+		c.stat.DBQueries.Add(1)
+	}
+	bytes, err := c.codec.Marshal(t)
+	if err != nil {
+		if c.stat != nil {
+			c.stat.MarshalErrors.Add(1)
+		}
+		return nil, err
+	}
+
+	if err := c.client.Set(ctx, cacheKey, bytes, c.expiration); err != nil {
+		log.Println(err.Error())
+	}
+
+	if len(extraSetFunc) > 0 {
+		if err := extraSetFunc[0](bytes); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return bytes, nil
+}
+
+// waitForCache はロックを取れなかった場合に、ロック保持者が埋めた本物のキャッシュを
+// pollIntervalごとにmaxWaitまでポーリングする
+func (c *Cache[T]) waitForCache(ctx context.Context, cacheKey string) ([]byte, bool) {
+	deadline := time.Now().Add(c.lockMaxWait)
+	ticker := time.NewTicker(c.lockPollInterval)
+	defer ticker.Stop()
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+			if bytes, exist, err := c.client.Get(ctx, cacheKey); err == nil && exist {
+				return bytes, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// releaseLock はLuaスクリプトでtokenを比較してからDELし、所有者以外がロックを奪うのを防ぐ
+func (c *Cache[T]) releaseLock(ctx context.Context, lockKey, token string) {
+	if _, err := c.client.Eval(ctx, releaseLockScript, []string{lockKey}, token); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func newLockToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Del はキーのキャッシュを削除する
+func (c *Cache[T]) Del(ctx context.Context, cacheKey string) error {
+	return c.client.Del(ctx, cacheKey)
+}
+
+// InvalidatePrefix はmatchPatternに一致する全キーをSCAN+パイプラインDELで削除する
+func (c *Cache[T]) InvalidatePrefix(ctx context.Context, matchPattern string) error {
+	_, err := c.client.ScanDel(ctx, matchPattern)
+	return err
+}
+
+// TagSetFunc はcacheKeyを指定したタグのRedis Setに登録するextraSetFuncを返す。
+// GetOrSetのextraSetFuncとして渡すことで、キャッシュを書き込むたびにタグ付けされる
+func (c *Cache[T]) TagSetFunc(ctx context.Context, cacheKey string, tags ...string) func([]byte) error {
+	return func([]byte) error {
+		for _, tag := range tags {
+			if err := c.client.SAdd(ctx, tagSetKey(tag), cacheKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// InvalidateTag はtagに紐づく全てのキャッシュキーをLuaスクリプトで一括削除する
+func (c *Cache[T]) InvalidateTag(ctx context.Context, tag string) error {
+	_, err := c.client.Eval(ctx, invalidateTagScript, []string{tagSetKey(tag)})
+	return err
+}
+
+func tagSetKey(tag string) string {
+	return "tag:" + tag
+}
@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Client はCacheが必要とするRedis操作を抽象化する
+type Client interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// GetCached はRESP3のクライアントサイドキャッシュに対応したバックエンド(rueidis)ではDoCacheを使う。
+	// 対応していないバックエンドではttlを無視してGetにフォールバックしてよい
+	GetCached(ctx context.Context, key string, ttl time.Duration) ([]byte, bool, error)
+	Set(ctx context.Context, key string, bytes []byte, expiration time.Duration) error
+	Del(ctx context.Context, key string) error
+	MGet(ctx context.Context, keys []string) ([]interface{}, bool, error)
+	MSet(ctx context.Context, values map[string]interface{}) error
+	SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error)
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+	// SAdd はSetにメンバーを追加する（タグベースの無効化に使う）
+	SAdd(ctx context.Context, key string, members ...string) error
+	// ScanDel はmatchPatternに一致する全キーをSCANでカーソル走査しながらパイプラインでDELする。
+	// 本番環境をブロッキングするKEYSコマンドは使わない。削除したキー数を返す
+	ScanDel(ctx context.Context, matchPattern string) (int, error)
+}
+
+// GoRedisClient はgo-redisを使ったClientの実装
+type GoRedisClient struct {
+	client *redis.Client
+}
+
+func NewGoRedisClient(ctx context.Context) *GoRedisClient {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		DB:           0,
+		PoolSize:     20,
+		MinIdleConns: 10,
+	})
+
+	// 疎通確認
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	return &GoRedisClient{
+		client: client,
+	}
+}
+
+// Redisクライアントの接続を閉じる
+func (c *GoRedisClient) Close() {
+	defer c.client.Close()
+}
+
+// キャッシュを取得する
+func (c *GoRedisClient) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	bytes, err := c.client.Get(ctx, key).Bytes()
+	// キャッシュが存在しない場合
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+	}
+
+	// キャッシュが存在する場合
+	return bytes, true, nil
+}
+
+// GetCached はgo-redisバックエンドではクライアントサイドキャッシュを持たないため、
+// ttlは無視して通常のGETにフォールバックする
+func (c *GoRedisClient) GetCached(ctx context.Context, key string, ttl time.Duration) ([]byte, bool, error) {
+	return c.Get(ctx, key)
+}
+
+// redisにvalueをsetする
+func (c *GoRedisClient) Set(ctx context.Context, key string, bytes []byte, expiration time.Duration) error {
+	if err := c.client.Set(ctx, key, bytes, expiration).Err(); err != nil {
+		return fmt.Errorf("failed to set to redis: %w", err)
+	}
+	return nil
+}
+
+// redisからvalueを削除する
+func (c *GoRedisClient) Del(ctx context.Context, key string) error {
+	if err := c.client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to delete from redis: %w", err)
+	}
+	return nil
+}
+
+// キャッシュを取得する
+func (c *GoRedisClient) MGet(ctx context.Context, keys []string) ([]interface{}, bool, error) {
+	result, err := c.client.MGet(ctx, keys...).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// redisに複数のvalueをsetする
+func (c *GoRedisClient) MSet(ctx context.Context, values map[string]interface{}) error {
+	if err := c.client.MSet(ctx, values).Err(); err != nil {
+		return fmt.Errorf("failed to set to redis: %w", err)
+	}
+	return nil
+}
+
+// NX PXでロックを取得する。既にキーが存在する場合は何もせずfalseを返す
+func (c *GoRedisClient) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	ok, err := c.client.SetNX(ctx, key, value, expiration).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to setnx to redis: %w", err)
+	}
+	return ok, nil
+}
+
+// Luaスクリプトを評価する
+func (c *GoRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	result, err := c.client.Eval(ctx, script, keys, args...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to eval script on redis: %w", err)
+	}
+	return result, nil
+}
+
+// Setにメンバーを追加する
+func (c *GoRedisClient) SAdd(ctx context.Context, key string, members ...string) error {
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	if err := c.client.SAdd(ctx, key, args...).Err(); err != nil {
+		return fmt.Errorf("failed to sadd to redis: %w", err)
+	}
+	return nil
+}
+
+// matchPatternに一致する全キーをSCANでカーソル走査しながらパイプラインでDELする
+func (c *GoRedisClient) ScanDel(ctx context.Context, matchPattern string) (int, error) {
+	var cursor uint64
+	deleted := 0
+
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, matchPattern, 100).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to scan redis: %w", err)
+		}
+
+		if len(keys) > 0 {
+			pipe := c.client.Pipeline()
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return deleted, fmt.Errorf("failed to pipeline del to redis: %w", err)
+			}
+			deleted += len(keys)
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return deleted, nil
+}
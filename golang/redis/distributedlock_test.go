@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCache_DistributedLock_LoserWaitsForWinner は、ロックを取れなかった側が自分では
+// callbackを実行せず、ロック保持者が埋めた本物のキャッシュをポーリングで取得することを確認する
+func TestCache_DistributedLock_LoserWaitsForWinner(t *testing.T) {
+	client := newFakeClient()
+
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	var winnerCalls, loserCalls int32
+
+	winner := NewCache[string](client, time.Minute, WithDistributedLock[string](time.Second, 5*time.Millisecond, time.Second))
+	loser := NewCache[string](client, time.Minute, WithDistributedLock[string](time.Second, 5*time.Millisecond, time.Second))
+
+	winnerDone := make(chan struct{})
+	go func() {
+		defer close(winnerDone)
+		_, err := winner.GetOrSet(context.Background(), "k", func(context.Context) (string, error) {
+			atomic.AddInt32(&winnerCalls, 1)
+			close(entered)
+			<-proceed
+			return "value-from-db", nil
+		})
+		if err != nil {
+			t.Errorf("winner GetOrSet: %v", err)
+		}
+	}()
+
+	<-entered // ロック保持者がcallback内でブロック中
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(proceed)
+	}()
+
+	loserResult, err := loser.GetOrSet(context.Background(), "k", func(context.Context) (string, error) {
+		atomic.AddInt32(&loserCalls, 1)
+		return "should-not-be-called", nil
+	})
+	<-winnerDone
+
+	if err != nil {
+		t.Fatalf("loser GetOrSet: %v", err)
+	}
+	if loserResult != "value-from-db" {
+		t.Fatalf("expected loser to observe winner's value, got %q", loserResult)
+	}
+	if got := atomic.LoadInt32(&loserCalls); got != 0 {
+		t.Fatalf("expected loser callback not to run, ran %d times", got)
+	}
+	if got := atomic.LoadInt32(&winnerCalls); got != 1 {
+		t.Fatalf("expected winner callback to run exactly once, ran %d times", got)
+	}
+}
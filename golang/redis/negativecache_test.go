@@ -0,0 +1,40 @@
+package redis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCache_NegativeCache_MissThenHit は、callbackがsql.ErrNoRowsを返した場合にErrNotFoundへ
+// 変換されること、そして2回目以降はネガティブキャッシュがヒットしてcallbackが呼ばれないことを確認する
+func TestCache_NegativeCache_MissThenHit(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	cache := NewCache[string](client, time.Minute, WithNegativeCache[string](time.Minute))
+
+	var calls int32
+	notFoundCallback := func(context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", sql.ErrNoRows
+	}
+
+	_, err := cache.GetOrSet(ctx, "missing", notFoundCallback)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected callback invoked once on miss, got %d", got)
+	}
+
+	_, err = cache.GetOrSet(ctx, "missing", notFoundCallback)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound on negative cache hit, got %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected callback NOT invoked again on negative cache hit, got %d calls", got)
+	}
+}
@@ -0,0 +1,63 @@
+// Package promcollector はCache[T]のStatをprometheus.Collectorとして公開するアダプタ。
+// redisパッケージ自体にprometheusへの依存を持ち込まないよう、別サブパッケージに分離している
+package promcollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/catatsuy/private-isu/webapp/golang/redis"
+)
+
+// Collector はredis.Statをラップし、prometheus.Collectorとして登録できるようにする
+type Collector struct {
+	stat *redis.Stat
+
+	hits            *prometheus.Desc
+	misses          *prometheus.Desc
+	dbQueries       *prometheus.Desc
+	dbErrors        *prometheus.Desc
+	marshalErrors   *prometheus.Desc
+	unmarshalErrors *prometheus.Desc
+	avgLatency      *prometheus.Desc
+}
+
+// NewCollector はstatを計測対象にしたCollectorを作る。statがnil（WithStats未指定）の場合、
+// Collectは何もメトリクスを出力しない
+func NewCollector(stat *redis.Stat) *Collector {
+	labels := []string{"name"}
+	return &Collector{
+		stat:            stat,
+		hits:            prometheus.NewDesc("cache_hits_total", "Number of cache hits", labels, nil),
+		misses:          prometheus.NewDesc("cache_misses_total", "Number of cache misses", labels, nil),
+		dbQueries:       prometheus.NewDesc("cache_db_queries_total", "Number of callback invocations that returned a value or a negatively cached not-found error", labels, nil),
+		dbErrors:        prometheus.NewDesc("cache_db_errors_total", "Number of callback invocations that returned an error other than a negatively cached not-found", labels, nil),
+		marshalErrors:   prometheus.NewDesc("cache_marshal_errors_total", "Number of codec marshal errors", labels, nil),
+		unmarshalErrors: prometheus.NewDesc("cache_unmarshal_errors_total", "Number of codec unmarshal errors", labels, nil),
+		avgLatency:      prometheus.NewDesc("cache_average_latency_seconds", "Average GetOrSet latency", labels, nil),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.dbQueries
+	ch <- c.dbErrors
+	ch <- c.marshalErrors
+	ch <- c.unmarshalErrors
+	ch <- c.avgLatency
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.stat == nil {
+		return
+	}
+
+	name := c.stat.Name
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(c.stat.Hits.Load()), name)
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(c.stat.Misses.Load()), name)
+	ch <- prometheus.MustNewConstMetric(c.dbQueries, prometheus.CounterValue, float64(c.stat.DBQueries.Load()), name)
+	ch <- prometheus.MustNewConstMetric(c.dbErrors, prometheus.CounterValue, float64(c.stat.DBErrors.Load()), name)
+	ch <- prometheus.MustNewConstMetric(c.marshalErrors, prometheus.CounterValue, float64(c.stat.MarshalErrors.Load()), name)
+	ch <- prometheus.MustNewConstMetric(c.unmarshalErrors, prometheus.CounterValue, float64(c.stat.UnmarshalErrors.Load()), name)
+	ch <- prometheus.MustNewConstMetric(c.avgLatency, prometheus.GaugeValue, c.stat.AverageLatency().Seconds(), name)
+}
@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// RueidisClient はrueidisを使ったClientの実装。RESP3のクライアントサイドキャッシュ(DoCache)で
+// GetCachedの読み取りを高速化する
+type RueidisClient struct {
+	client rueidis.Client
+}
+
+func NewRueidisClient(ctx context.Context) (*RueidisClient, error) {
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress: []string{"127.0.0.1:6379"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	if err := client.Do(ctx, client.B().Ping().Build()).Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RueidisClient{client: client}, nil
+}
+
+func (c *RueidisClient) Close() {
+	c.client.Close()
+}
+
+// Get はクライアントサイドキャッシュを使わずに取得する
+func (c *RueidisClient) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp := c.client.Do(ctx, c.client.B().Get().Key(key).Build())
+	return rueidisBytes(resp)
+}
+
+// GetCached はRESP3のクライアントサイドキャッシュ(DoCache)を使って取得する。
+// ttlが0の場合は通常のGETにフォールバックする
+func (c *RueidisClient) GetCached(ctx context.Context, key string, ttl time.Duration) ([]byte, bool, error) {
+	if ttl <= 0 {
+		return c.Get(ctx, key)
+	}
+
+	resp := c.client.DoCache(ctx, c.client.B().Get().Key(key).Cache(), ttl)
+	return rueidisBytes(resp)
+}
+
+func rueidisBytes(resp rueidis.RedisResult) ([]byte, bool, error) {
+	bytes, err := resp.AsBytes()
+	if rueidis.IsRedisNil(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+	}
+	return bytes, true, nil
+}
+
+func (c *RueidisClient) Set(ctx context.Context, key string, bytes []byte, expiration time.Duration) error {
+	cmd := c.client.B().Set().Key(key).Value(rueidis.BinaryString(bytes)).Ex(expiration).Build()
+	if err := c.client.Do(ctx, cmd).Error(); err != nil {
+		return fmt.Errorf("failed to set to redis: %w", err)
+	}
+	return nil
+}
+
+func (c *RueidisClient) Del(ctx context.Context, key string) error {
+	if err := c.client.Do(ctx, c.client.B().Del().Key(key).Build()).Error(); err != nil {
+		return fmt.Errorf("failed to delete from redis: %w", err)
+	}
+	return nil
+}
+
+func (c *RueidisClient) MGet(ctx context.Context, keys []string) ([]interface{}, bool, error) {
+	resp := c.client.Do(ctx, c.client.B().Mget().Key(keys...).Build())
+	arr, err := resp.ToArray()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+	}
+
+	result := make([]interface{}, len(arr))
+	for i, v := range arr {
+		s, err := v.ToString()
+		if err != nil {
+			result[i] = nil
+			continue
+		}
+		result[i] = s
+	}
+
+	return result, true, nil
+}
+
+func (c *RueidisClient) MSet(ctx context.Context, values map[string]interface{}) error {
+	b := c.client.B().Mset().KeyValue()
+	for k, v := range values {
+		b = b.KeyValue(k, fmt.Sprintf("%v", v))
+	}
+
+	if err := c.client.Do(ctx, b.Build()).Error(); err != nil {
+		return fmt.Errorf("failed to set to redis: %w", err)
+	}
+	return nil
+}
+
+func (c *RueidisClient) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	cmd := c.client.B().Set().Key(key).Value(value).Nx().Px(expiration).Build()
+	err := c.client.Do(ctx, cmd).Error()
+	if rueidis.IsRedisNil(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to setnx to redis: %w", err)
+	}
+	return true, nil
+}
+
+func (c *RueidisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	argv := make([]string, len(args))
+	for i, a := range args {
+		argv[i] = fmt.Sprintf("%v", a)
+	}
+
+	resp := c.client.Do(ctx, c.client.B().Eval().Script(script).Numkeys(int64(len(keys))).Key(keys...).Arg(argv...).Build())
+	result, err := resp.ToAny()
+	if err != nil {
+		return nil, fmt.Errorf("failed to eval script on redis: %w", err)
+	}
+	return result, nil
+}
+
+// Setにメンバーを追加する
+func (c *RueidisClient) SAdd(ctx context.Context, key string, members ...string) error {
+	cmd := c.client.B().Sadd().Key(key).Member(members...).Build()
+	if err := c.client.Do(ctx, cmd).Error(); err != nil {
+		return fmt.Errorf("failed to sadd to redis: %w", err)
+	}
+	return nil
+}
+
+// matchPatternに一致する全キーをSCANでカーソル走査しながらパイプラインでDELする
+func (c *RueidisClient) ScanDel(ctx context.Context, matchPattern string) (int, error) {
+	var cursor uint64
+	deleted := 0
+
+	for {
+		resp := c.client.Do(ctx, c.client.B().Scan().Cursor(cursor).Match(matchPattern).Count(100).Build())
+		entry, err := resp.AsScanEntry()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to scan redis: %w", err)
+		}
+
+		if len(entry.Elements) > 0 {
+			cmds := make(rueidis.Commands, 0, len(entry.Elements))
+			for _, key := range entry.Elements {
+				cmds = append(cmds, c.client.B().Del().Key(key).Build())
+			}
+			for _, resp := range c.client.DoMulti(ctx, cmds...) {
+				if err := resp.Error(); err != nil {
+					return deleted, fmt.Errorf("failed to del from redis: %w", err)
+				}
+			}
+			deleted += len(entry.Elements)
+		}
+
+		cursor = entry.Cursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return deleted, nil
+}
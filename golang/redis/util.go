@@ -20,12 +20,14 @@ type RedisRepository[T any] struct {
 func NewRedisRepository[T any](
 	db db,
 	cacheClient Client,
+	opts ...CacheOption[T],
 ) *RedisRepository[T] {
 	return &RedisRepository[T]{
 		db: db,
 		Cache: NewCache[T](
 			cacheClient,
 			time.Second*10,
+			opts...,
 		),
 	}
 }
@@ -60,6 +62,56 @@ func (r *RedisRepository[T]) GetByColumn(
 	)
 }
 
+// GetByColumnWithTags はGetByColumnと同様だが、書き込まれたキャッシュキーを指定したタグに
+// 登録する。InvalidateTagで関連するキャッシュをまとめて無効化できるようになる
+func (r *RedisRepository[T]) GetByColumnWithTags(
+	ctx context.Context,
+	columnName string,
+	columnValue string,
+	tableName string,
+	tags []string,
+	columns ...string,
+) (T, error) {
+	cacheKey := fmt.Sprintf("%s:%s:%s", tableName, columnName, columnValue)
+
+	return r.Cache.GetOrSet(
+		ctx, cacheKey, func(ctx context.Context) (T, error) {
+			var result T
+			dest := any(&result)
+
+			selectColumns := "*"
+			if len(columns) > 0 {
+				selectColumns = strings.Join(columns, ", ")
+			}
+
+			query := fmt.Sprintf("SELECT %s FROM `%s` WHERE %s = ?", selectColumns, tableName, columnName)
+
+			if err := r.db.GetContext(ctx, dest, query, columnValue); err != nil {
+				return result, err
+			}
+
+			return result, nil
+		},
+		r.Cache.TagSetFunc(ctx, cacheKey, tags...),
+	)
+}
+
+// Invalidate は指定したcolumnName/columnValueに対応するキャッシュを削除する
+func (r *RedisRepository[T]) Invalidate(ctx context.Context, tableName, columnName, columnValue string) error {
+	cacheKey := fmt.Sprintf("%s:%s:%s", tableName, columnName, columnValue)
+	return r.Cache.Del(ctx, cacheKey)
+}
+
+// InvalidateTable はtableNameに紐づく全てのキャッシュキーをSCAN+パイプラインDELで削除する
+func (r *RedisRepository[T]) InvalidateTable(ctx context.Context, tableName string) error {
+	return r.Cache.InvalidatePrefix(ctx, fmt.Sprintf("%s:*", tableName))
+}
+
+// InvalidateTag は指定したタグに紐づく全てのキャッシュキーを一括で削除する
+func (r *RedisRepository[T]) InvalidateTag(ctx context.Context, tag string) error {
+	return r.Cache.InvalidateTag(ctx, tag)
+}
+
 func (r *RedisRepository[T]) GetById(
 	ctx context.Context,
 	id string,
@@ -173,6 +225,17 @@ func (r *RedisRepository[T]) SelectByColumn(
 	)
 }
 
+// listCacheKey はSelectByColumnWithLimit/SelectByColumnWithPagination/SelectByColumnAfterIDが
+// 使うキャッシュキーを組み立てる。columnName/columnValueを含めないと、異なる絞り込み条件の
+// 結果がlimit/offsetだけで衝突してしまうため、クエリを構成する全入力を含める
+func listCacheKey(tableName, columnName, columnValue string, columns []string, suffix string) string {
+	selectColumns := "*"
+	if len(columns) > 0 {
+		selectColumns = strings.Join(columns, ",")
+	}
+	return fmt.Sprintf("%s:%s:%s:cols:%s:%s", tableName, columnName, columnValue, selectColumns, suffix)
+}
+
 func (r *RedisRepository[T]) SelectByColumnWithLimit(
 	ctx context.Context,
 	columnName string,
@@ -181,7 +244,7 @@ func (r *RedisRepository[T]) SelectByColumnWithLimit(
 	limit int,
 	columns ...string,
 ) (T, error) {
-	cacheKey := fmt.Sprintf("%s:limit:%v", tableName, limit)
+	cacheKey := listCacheKey(tableName, columnName, columnValue, columns, fmt.Sprintf("limit:%v", limit))
 
 	return r.Cache.GetOrSet(
 		ctx, cacheKey, func(ctx context.Context) (T, error) {
@@ -203,3 +266,74 @@ func (r *RedisRepository[T]) SelectByColumnWithLimit(
 		},
 	)
 }
+
+// SelectByColumnWithPagination はSelectByColumnWithLimitにoffsetを加えたページング版。
+// limitとoffsetの組ごとにキャッシュキーが分かれるため、ページをまたいでも結果が衝突しない
+func (r *RedisRepository[T]) SelectByColumnWithPagination(
+	ctx context.Context,
+	columnName string,
+	columnValue string,
+	tableName string,
+	limit int,
+	offset int,
+	columns ...string,
+) (T, error) {
+	cacheKey := listCacheKey(tableName, columnName, columnValue, columns, fmt.Sprintf("limit:%v:offset:%v", limit, offset))
+
+	return r.Cache.GetOrSet(
+		ctx, cacheKey, func(ctx context.Context) (T, error) {
+			var results T
+			dest := any(&results)
+
+			selectColumns := "*"
+			if len(columns) > 0 {
+				selectColumns = strings.Join(columns, ", ")
+			}
+
+			query := fmt.Sprintf("SELECT %s FROM `%s` WHERE %s = ? LIMIT %v OFFSET %v", selectColumns, tableName, columnName, limit, offset)
+
+			if err := r.db.SelectContext(ctx, dest, query, columnValue); err != nil {
+				return results, err
+			}
+
+			return results, nil
+		},
+	)
+}
+
+// SelectByColumnAfterID はidのキーセットページネーション版。OFFSETと違い、idより後ろの行だけを
+// 読むのでページが深くなってもスキャン量が増えない
+func (r *RedisRepository[T]) SelectByColumnAfterID(
+	ctx context.Context,
+	columnName string,
+	columnValue string,
+	tableName string,
+	afterID string,
+	limit int,
+	columns ...string,
+) (T, error) {
+	cacheKey := listCacheKey(tableName, columnName, columnValue, columns, fmt.Sprintf("after:%s:limit:%v", afterID, limit))
+
+	return r.Cache.GetOrSet(
+		ctx, cacheKey, func(ctx context.Context) (T, error) {
+			var results T
+			dest := any(&results)
+
+			selectColumns := "*"
+			if len(columns) > 0 {
+				selectColumns = strings.Join(columns, ", ")
+			}
+
+			query := fmt.Sprintf(
+				"SELECT %s FROM `%s` WHERE %s = ? AND id > ? ORDER BY id LIMIT %v",
+				selectColumns, tableName, columnName, limit,
+			)
+
+			if err := r.db.SelectContext(ctx, dest, query, columnValue, afterID); err != nil {
+				return results, err
+			}
+
+			return results, nil
+		},
+	)
+}
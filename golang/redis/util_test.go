@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRow struct {
+	ID   string `db:"id"`
+	Name string `db:"name"`
+}
+
+// fakeDB はdbインターフェースを満たす、固定レスポンスを返すだけのテスト用実装
+type fakeDB struct {
+	calls int
+	rows  map[string][]fakeRow // columnValueごとに返す行
+}
+
+func (f *fakeDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return nil
+}
+
+func (f *fakeDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	f.calls++
+	columnValue, _ := args[0].(string)
+	out := dest.(*[]fakeRow)
+	*out = f.rows[columnValue]
+	return nil
+}
+
+// fakeClient はClientインターフェースを満たす、メモリ上のマップだけでできたテスト用実装。
+// SetNXは本物のNXセマンティクス（キーが存在する場合は何もせずfalse）を再現しており、
+// 分散ロックのテストでも使える
+type fakeClient struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{data: make(map[string][]byte)}
+}
+
+func (f *fakeClient) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.data[key]
+	return v, ok, nil
+}
+
+func (f *fakeClient) GetCached(ctx context.Context, key string, ttl time.Duration) ([]byte, bool, error) {
+	return f.Get(ctx, key)
+}
+
+func (f *fakeClient) Set(ctx context.Context, key string, bytes []byte, expiration time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[key] = bytes
+	return nil
+}
+
+func (f *fakeClient) Del(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.data, key)
+	return nil
+}
+
+func (f *fakeClient) MGet(ctx context.Context, keys []string) ([]interface{}, bool, error) {
+	return nil, false, nil
+}
+
+func (f *fakeClient) MSet(ctx context.Context, values map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeClient) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.data[key]; exists {
+		return false, nil
+	}
+	f.data[key] = []byte(value)
+	return true, nil
+}
+
+func (f *fakeClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	// releaseLockScriptの簡易再現: tokenが一致する場合のみ削除する
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(keys) == 1 && len(args) == 1 {
+		if token, ok := args[0].(string); ok && string(f.data[keys[0]]) == token {
+			delete(f.data, keys[0])
+			return int64(1), nil
+		}
+	}
+	return int64(0), nil
+}
+
+func (f *fakeClient) SAdd(ctx context.Context, key string, members ...string) error {
+	return nil
+}
+
+func (f *fakeClient) ScanDel(ctx context.Context, matchPattern string) (int, error) {
+	return 0, nil
+}
+
+// TestSelectByColumnWithLimit_NoKeyCollision は、columnName/columnValueが異なり
+// limitだけが同じ呼び出しがキャッシュキーを共有しない（＝互いの結果を返さない）ことを確認する
+func TestSelectByColumnWithLimit_NoKeyCollision(t *testing.T) {
+	ctx := context.Background()
+
+	db := &fakeDB{
+		rows: map[string][]fakeRow{
+			"alice": {{ID: "1", Name: "alice"}},
+			"bob":   {{ID: "2", Name: "bob"}},
+		},
+	}
+	client := newFakeClient()
+	repo := NewRedisRepository[[]fakeRow](db, client)
+
+	alice, err := repo.SelectByColumnWithLimit(ctx, "name", "alice", "users", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bob, err := repo.SelectByColumnWithLimit(ctx, "name", "bob", "users", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if db.calls != 2 {
+		t.Fatalf("expected 2 DB calls (one per distinct query), got %d; second call served a colliding cache entry", db.calls)
+	}
+
+	if reflect.DeepEqual(alice, bob) {
+		t.Fatalf("expected different results for different columnValue, got identical results: %+v", alice)
+	}
+
+	aliceKey := listCacheKey("users", "name", "alice", nil, "limit:10")
+	bobKey := listCacheKey("users", "name", "bob", nil, "limit:10")
+	if aliceKey == bobKey {
+		t.Fatalf("expected distinct cache keys for different columnValue, got same key %q", aliceKey)
+	}
+}
@@ -0,0 +1,233 @@
+//go:build rueidis
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// RedisClient はrueidisを使い、RESP3のクライアントサイドキャッシュ(DoCache)で
+// 読み取りを高速化する。`-tags rueidis`でビルドした場合にこちらが使われる。
+type RedisClient struct {
+	client rueidis.Client
+}
+
+func NewRedisClient(ctx context.Context) *RedisClient {
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress: []string{"127.0.0.1:6379"},
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	// 疎通確認
+	if err := client.Do(ctx, client.B().Ping().Build()).Error(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	return &RedisClient{
+		client: client,
+	}
+}
+
+// Redisクライアントの接続を閉じる
+func (c *RedisClient) Close() {
+	c.client.Close()
+}
+
+func (c *RedisClient) Clear() error {
+	return c.client.Do(context.Background(), c.client.B().Flushall().Build()).Error()
+}
+
+// キャッシュを取得する（クライアントサイドキャッシュは使わない）
+func (c *RedisClient) Get(
+	ctx context.Context,
+	key string,
+) ([]byte, bool, error) {
+	resp := c.client.Do(ctx, c.client.B().Get().Key(key).Build())
+	return bytesFromResp(resp)
+}
+
+// GetCached はRESP3のクライアントサイドキャッシュ(DoCache)を使って取得する。
+// ttlが0の場合は通常のGETにフォールバックする
+func (c *RedisClient) GetCached(
+	ctx context.Context,
+	key string,
+	ttl time.Duration,
+) ([]byte, bool, error) {
+	if ttl <= 0 {
+		return c.Get(ctx, key)
+	}
+
+	resp := c.client.DoCache(ctx, c.client.B().Get().Key(key).Cache(), ttl)
+	return bytesFromResp(resp)
+}
+
+func bytesFromResp(resp rueidis.RedisResult) ([]byte, bool, error) {
+	bytes, err := resp.AsBytes()
+	if rueidis.IsRedisNil(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+	}
+	return bytes, true, nil
+}
+
+// redisにvalueをsetする
+func (c *RedisClient) Set(
+	ctx context.Context,
+	key string,
+	bytes []byte,
+	expiration time.Duration,
+) error {
+	cmd := c.client.B().Set().Key(key).Value(rueidis.BinaryString(bytes)).Ex(expiration).Build()
+	if err := c.client.Do(ctx, cmd).Error(); err != nil {
+		return fmt.Errorf("failed to set to redis: %w", err)
+	}
+	return nil
+}
+
+// redisからvalueを削除する
+func (c *RedisClient) Del(
+	ctx context.Context,
+	key string,
+) error {
+	if err := c.client.Do(ctx, c.client.B().Del().Key(key).Build()).Error(); err != nil {
+		return fmt.Errorf("failed to delete from redis: %w", err)
+	}
+	return nil
+}
+
+// キャッシュを取得する
+func (c *RedisClient) MGet(
+	ctx context.Context,
+	keys []string,
+) ([]interface{}, bool, error) {
+	resp := c.client.Do(ctx, c.client.B().Mget().Key(keys...).Build())
+	arr, err := resp.ToArray()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get from redis: %w", err)
+	}
+
+	result := make([]interface{}, len(arr))
+	for i, v := range arr {
+		s, err := v.ToString()
+		if err != nil {
+			result[i] = nil
+			continue
+		}
+		result[i] = s
+	}
+
+	return result, true, nil
+}
+
+// redisに複数のvalueをsetする
+func (c *RedisClient) MSet(
+	ctx context.Context,
+	values map[string]interface{},
+) error {
+	b := c.client.B().Mset().KeyValue()
+	for k, v := range values {
+		b = b.KeyValue(k, fmt.Sprintf("%v", v))
+	}
+
+	if err := c.client.Do(ctx, b.Build()).Error(); err != nil {
+		return fmt.Errorf("failed to set to redis: %w", err)
+	}
+	return nil
+}
+
+// NX PXでロックを取得する。既にキーが存在する場合は何もせずfalseを返す
+func (c *RedisClient) SetNX(
+	ctx context.Context,
+	key string,
+	value string,
+	expiration time.Duration,
+) (bool, error) {
+	cmd := c.client.B().Set().Key(key).Value(value).Nx().Px(expiration).Build()
+	err := c.client.Do(ctx, cmd).Error()
+	if rueidis.IsRedisNil(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to setnx to redis: %w", err)
+	}
+	return true, nil
+}
+
+// Luaスクリプトを評価する
+func (c *RedisClient) Eval(
+	ctx context.Context,
+	script string,
+	keys []string,
+	args ...interface{},
+) (interface{}, error) {
+	argv := make([]string, len(args))
+	for i, a := range args {
+		argv[i] = fmt.Sprintf("%v", a)
+	}
+
+	resp := c.client.Do(ctx, c.client.B().Eval().Script(script).Numkeys(int64(len(keys))).Key(keys...).Arg(argv...).Build())
+	result, err := resp.ToAny()
+	if err != nil {
+		return nil, fmt.Errorf("failed to eval script on redis: %w", err)
+	}
+	return result, nil
+}
+
+// Setにメンバーを追加する
+func (c *RedisClient) SAdd(
+	ctx context.Context,
+	key string,
+	members ...string,
+) error {
+	cmd := c.client.B().Sadd().Key(key).Member(members...).Build()
+	if err := c.client.Do(ctx, cmd).Error(); err != nil {
+		return fmt.Errorf("failed to sadd to redis: %w", err)
+	}
+	return nil
+}
+
+// matchPatternに一致する全キーをSCANでカーソル走査しながらパイプラインでDELする
+func (c *RedisClient) ScanDel(
+	ctx context.Context,
+	matchPattern string,
+) (int, error) {
+	var cursor uint64
+	deleted := 0
+
+	for {
+		resp := c.client.Do(ctx, c.client.B().Scan().Cursor(cursor).Match(matchPattern).Count(100).Build())
+		entry, err := resp.AsScanEntry()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to scan redis: %w", err)
+		}
+
+		if len(entry.Elements) > 0 {
+			cmds := make(rueidis.Commands, 0, len(entry.Elements))
+			for _, key := range entry.Elements {
+				cmds = append(cmds, c.client.B().Del().Key(key).Build())
+			}
+			for _, resp := range c.client.DoMulti(ctx, cmds...) {
+				if err := resp.Error(); err != nil {
+					return deleted, fmt.Errorf("failed to del from redis: %w", err)
+				}
+			}
+			deleted += len(entry.Elements)
+		}
+
+		cursor = entry.Cursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return deleted, nil
+}
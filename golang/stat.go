@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stat はCache[T]の1インスタンスに対応するヒット率・レイテンシの統計情報
+type Stat struct {
+	Name string
+
+	Hits            atomic.Int64
+	Misses          atomic.Int64
+	DBQueries       atomic.Int64
+	DBErrors        atomic.Int64
+	MarshalErrors   atomic.Int64
+	UnmarshalErrors atomic.Int64
+
+	latencyMu    sync.Mutex
+	latencySum   time.Duration
+	latencyCount int64
+}
+
+func newStat(name string) *Stat {
+	return &Stat{Name: name}
+}
+
+func (s *Stat) observeLatency(d time.Duration) {
+	s.latencyMu.Lock()
+	s.latencySum += d
+	s.latencyCount++
+	s.latencyMu.Unlock()
+}
+
+// AverageLatency はGetOrSetの累積レイテンシの平均を返す
+func (s *Stat) AverageLatency() time.Duration {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	if s.latencyCount == 0 {
+		return 0
+	}
+	return s.latencySum / time.Duration(s.latencyCount)
+}
+
+// String はログに出力するサマリ行を返す
+func (s *Stat) String() string {
+	return fmt.Sprintf(
+		"cache[%s] hits=%d misses=%d db_queries=%d db_errors=%d marshal_errors=%d unmarshal_errors=%d avg_latency=%s",
+		s.Name,
+		s.Hits.Load(),
+		s.Misses.Load(),
+		s.DBQueries.Load(),
+		s.DBErrors.Load(),
+		s.MarshalErrors.Load(),
+		s.UnmarshalErrors.Load(),
+		s.AverageLatency(),
+	)
+}